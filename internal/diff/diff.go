@@ -0,0 +1,192 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diff computes stable RFC 6902 JSON Patch documents between two
+// revisions of a Helm release, for use in HelmRelease status and Kubernetes
+// Events so operators can see "why did this reconcile fire?" without
+// shelling into the cluster.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+)
+
+// redactedValue is substituted for the value of any operation whose path
+// matches a redacted field when Options.RedactSecrets is set.
+const redactedValue = "**REDACTED**"
+
+// Options configures how a Patch is computed and rendered. It mirrors
+// HelmRelease.Spec.DiffOptions.
+type Options struct {
+	// MaxBytes is the maximum size in bytes of the operations listed in the
+	// rendered patch. A patch exceeding this size is truncated, with a note
+	// appended indicating how many operations were omitted; that note is not
+	// counted against MaxBytes. Zero means unbounded.
+	MaxBytes int
+	// RedactSecrets replaces the value of any operation believed to hold
+	// sensitive data (e.g. a Secret's `data`/`stringData`, or a values path
+	// conventionally used for credentials) with a fixed placeholder.
+	RedactSecrets bool
+	// IgnorePaths is a list of JSON Pointers (RFC 6901) for which operations
+	// are dropped from the resulting patch before it is rendered.
+	IgnorePaths []string
+}
+
+// sensitivePathSubstrings are substrings that, when found anywhere in an
+// operation's path (compared case-insensitively, not segment by segment),
+// mark it as holding potentially sensitive data.
+var sensitivePathSubstrings = []string{"/data", "/stringData", "password", "token", "secret"}
+
+// Patch is a stable, human-readable rendering of an RFC 6902 JSON Patch
+// between two JSON documents.
+type Patch struct {
+	// Operations is the (possibly filtered and redacted) set of JSON Patch
+	// operations that transform before into after.
+	Operations []jsonpatch.Operation
+	// Truncated indicates that the rendered string representation omits one
+	// or more operations to satisfy Options.MaxBytes.
+	Truncated bool
+}
+
+// Compute returns the Patch that transforms before into after, both of
+// which must be valid JSON documents. Operations matching Options.IgnorePaths
+// are dropped, and operations are sorted by path for a stable rendering
+// across repeated reconciliations of otherwise-identical changes.
+func Compute(before, after []byte, opts Options) (Patch, error) {
+	ops, err := jsonpatch.CreatePatch(before, after)
+	if err != nil {
+		return Patch{}, fmt.Errorf("failed to compute JSON patch: %w", err)
+	}
+
+	ops = filterIgnored(ops, opts.IgnorePaths)
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Path < ops[j].Path })
+
+	if opts.RedactSecrets {
+		ops = redact(ops)
+	}
+
+	return Patch{Operations: ops}, nil
+}
+
+// Values returns the Patch between two sets of Helm values, as recorded on
+// a release and desired by a HelmRelease object respectively.
+func Values(before, after map[string]interface{}, opts Options) (Patch, error) {
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return Patch{}, fmt.Errorf("failed to marshal before values: %w", err)
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return Patch{}, fmt.Errorf("failed to marshal after values: %w", err)
+	}
+	return Compute(beforeJSON, afterJSON, opts)
+}
+
+// Object returns the Patch between two Kubernetes objects represented as
+// generic maps (e.g. obtained via runtime.DefaultUnstructuredConverter).
+func Object(before, after map[string]interface{}, opts Options) (Patch, error) {
+	return Values(before, after, opts)
+}
+
+// String renders the Patch as a compact, human-readable summary of one
+// operation per line, truncated once the listed operations would exceed
+// maxBytes (if greater than zero), in which case a final line noting the
+// number of omitted operations is appended and p.Truncated is set. The
+// note itself is not counted against maxBytes. An empty Patch renders as
+// the empty string.
+func (p *Patch) String(maxBytes int) string {
+	if len(p.Operations) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	var written int
+	for i, op := range p.Operations {
+		line := fmt.Sprintf("%s %s", op.Operation, op.Path)
+		if op.Value != nil {
+			line = fmt.Sprintf("%s: %v", line, op.Value)
+		}
+		if maxBytes > 0 && written+len(line)+1 > maxBytes {
+			remaining := len(p.Operations) - i
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(fmt.Sprintf("... %d more change(s) omitted", remaining))
+			p.Truncated = true
+			return b.String()
+		}
+		if i > 0 {
+			b.WriteString("\n")
+			written++
+		}
+		b.WriteString(line)
+		written += len(line)
+	}
+	return b.String()
+}
+
+// filterIgnored drops any operation whose path matches one of the given
+// JSON Pointers, or is a descendant of one.
+func filterIgnored(ops []jsonpatch.Operation, ignorePaths []string) []jsonpatch.Operation {
+	if len(ignorePaths) == 0 {
+		return ops
+	}
+
+	filtered := ops[:0]
+	for _, op := range ops {
+		var ignore bool
+		for _, p := range ignorePaths {
+			if op.Path == p || strings.HasPrefix(op.Path, p+"/") {
+				ignore = true
+				break
+			}
+		}
+		if !ignore {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// redact replaces the value of any operation whose path looks like it holds
+// sensitive data with a fixed placeholder.
+func redact(ops []jsonpatch.Operation) []jsonpatch.Operation {
+	redacted := make([]jsonpatch.Operation, len(ops))
+	for i, op := range ops {
+		if op.Value != nil && isSensitivePath(op.Path) {
+			op.Value = redactedValue
+		}
+		redacted[i] = op
+	}
+	return redacted
+}
+
+// isSensitivePath returns true if path contains, case-insensitively, any of
+// sensitivePathSubstrings.
+func isSensitivePath(path string) bool {
+	lower := strings.ToLower(path)
+	for _, s := range sensitivePathSubstrings {
+		if strings.Contains(lower, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
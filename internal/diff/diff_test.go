@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// Test_Compute_IgnorePaths asserts that operations matching an ignored JSON
+// Pointer, or a descendant of one, are dropped from the resulting Patch.
+func Test_Compute_IgnorePaths(t *testing.T) {
+	g := NewWithT(t)
+
+	before := []byte(`{"spec":{"replicas":1},"status":{"ready":true}}`)
+	after := []byte(`{"spec":{"replicas":2},"status":{"ready":false}}`)
+
+	patch, err := Compute(before, after, Options{IgnorePaths: []string{"/status"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(patch.Operations).To(HaveLen(1))
+	g.Expect(patch.Operations[0].Path).To(Equal("/spec/replicas"))
+}
+
+// Test_Compute_RedactSecrets asserts that the value of an operation whose
+// path looks like it holds sensitive data is replaced with a fixed
+// placeholder, while unrelated operations are left untouched.
+func Test_Compute_RedactSecrets(t *testing.T) {
+	g := NewWithT(t)
+
+	before := []byte(`{"data":{"password":"old"},"spec":{"replicas":1}}`)
+	after := []byte(`{"data":{"password":"new"},"spec":{"replicas":2}}`)
+
+	patch, err := Compute(before, after, Options{RedactSecrets: true})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var sawRedacted, sawReplicas bool
+	for _, op := range patch.Operations {
+		switch op.Path {
+		case "/data/password":
+			g.Expect(op.Value).To(Equal(redactedValue))
+			sawRedacted = true
+		case "/spec/replicas":
+			g.Expect(op.Value).To(Equal(float64(2)))
+			sawReplicas = true
+		}
+	}
+	g.Expect(sawRedacted).To(BeTrue(), "expected /data/password to be redacted")
+	g.Expect(sawReplicas).To(BeTrue(), "expected /spec/replicas to be left untouched")
+}
+
+// Test_Patch_String_MaxBytes asserts that String truncates its rendering
+// once maxBytes is exceeded, appends an omission count, and records that on
+// the Patch via Truncated. Without a maxBytes limit, no truncation occurs.
+func Test_Patch_String_MaxBytes(t *testing.T) {
+	g := NewWithT(t)
+
+	before := []byte(`{"a":1,"b":2,"c":3}`)
+	after := []byte(`{"a":10,"b":20,"c":30}`)
+
+	patch, err := Compute(before, after, Options{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(patch.Operations).To(HaveLen(3))
+
+	full := patch.String(0)
+	g.Expect(patch.Truncated).To(BeFalse())
+	g.Expect(full).To(ContainSubstring("/a"))
+	g.Expect(full).To(ContainSubstring("/b"))
+	g.Expect(full).To(ContainSubstring("/c"))
+
+	truncated := patch.String(1)
+	g.Expect(patch.Truncated).To(BeTrue())
+	g.Expect(truncated).To(ContainSubstring("more change(s) omitted"))
+}
+
+// Test_Patch_String_Empty asserts that a Patch with no operations renders as
+// the empty string and is never marked as Truncated.
+func Test_Patch_String_Empty(t *testing.T) {
+	g := NewWithT(t)
+
+	patch := Patch{}
+	g.Expect(patch.String(0)).To(Equal(""))
+	g.Expect(patch.Truncated).To(BeFalse())
+}
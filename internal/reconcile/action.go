@@ -21,66 +21,15 @@ import (
 	"fmt"
 
 	helmrelease "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/diff"
 	interrors "github.com/fluxcd/helm-controller/internal/errors"
 	"github.com/fluxcd/helm-controller/internal/release"
 )
 
-// ReleaseStatus represents the status of a Helm release as determined by
-// comparing the Helm storage with the v2beta2.HelmRelease object.
-type ReleaseStatus string
-
-// String returns the string representation of the release status.
-func (s ReleaseStatus) String() string {
-	return string(s)
-}
-
-const (
-	// ReleaseStatusUnknown indicates that the status of the release could not
-	// be determined.
-	ReleaseStatusUnknown ReleaseStatus = "Unknown"
-	// ReleaseStatusAbsent indicates that the release is not present in the
-	// Helm storage.
-	ReleaseStatusAbsent ReleaseStatus = "Absent"
-	// ReleaseStatusOrphaned indicates that the release is present in the Helm
-	// storage, but is not managed by the v2beta2.HelmRelease object.
-	ReleaseStatusOrphaned ReleaseStatus = "Orphaned"
-	// ReleaseStatusOutOfSync indicates that the release is present in the Helm
-	// storage, but is not in sync with the v2beta2.HelmRelease object.
-	ReleaseStatusOutOfSync ReleaseStatus = "OutOfSync"
-	// ReleaseStatusLocked indicates that the release is present in the Helm
-	// storage, but is locked.
-	ReleaseStatusLocked ReleaseStatus = "Locked"
-	// ReleaseStatusUntested indicates that the release is present in the Helm
-	// storage, but has not been tested.
-	ReleaseStatusUntested ReleaseStatus = "Untested"
-	// ReleaseStatusInSync indicates that the release is present in the Helm
-	// storage, and is in sync with the v2beta2.HelmRelease object.
-	ReleaseStatusInSync ReleaseStatus = "InSync"
-	// ReleaseStatusFailed indicates that the release is present in the Helm
-	// storage, but has failed.
-	ReleaseStatusFailed ReleaseStatus = "Failed"
-)
-
-// ReleaseState represents the state of a Helm release as determined by
-// comparing the Helm storage with the v2beta2.HelmRelease object.
-type ReleaseState struct {
-	// Status is the status of the release.
-	Status ReleaseStatus
-	// Reason for the Status.
-	Reason string
-}
-
-// MustResetHistory returns true if the release state indicates that the
-// history on the v2beta2.HelmRelease object must be reset.
-// This is the case when the release in storage has been mutated in such a way
-// that it no longer can be used to roll back to, or perform a diff against.
-func (s ReleaseState) MustResetHistory() bool {
-	return s.Status == ReleaseStatusLocked || s.Status == ReleaseStatusOrphaned || s.Status == ReleaseStatusAbsent
-}
-
 func DetermineReleaseState(cfg *action.ConfigFactory, req *Request) (ReleaseState, error) {
 	rls, err := action.LastRelease(cfg.Build(nil), req.Object.GetReleaseName())
 	if err != nil {
@@ -119,7 +68,13 @@ func DetermineReleaseState(cfg *action.ConfigFactory, req *Request) (ReleaseStat
 		if err = action.VerifyRelease(rls, cur, req.Chart.Metadata, req.Values); err != nil {
 			switch err {
 			case action.ErrChartChanged, action.ErrConfigDigest:
-				return ReleaseState{Status: ReleaseStatusOutOfSync, Reason: err.Error()}, nil
+				state := ReleaseState{Status: ReleaseStatusOutOfSync, Reason: err.Error()}
+				if d, dErr := diff.Values(rls.Config, req.Values, req.DiffOptions); dErr == nil {
+					state.Diff = d.String(req.DiffOptions.MaxBytes)
+					state.DiffTruncated = d.Truncated
+					recordDiffEvent(req, "OutOfSync", state.Diff)
+				}
+				return state, nil
 			default:
 				return ReleaseState{Status: ReleaseStatusUnknown}, err
 			}
@@ -140,8 +95,40 @@ func DetermineReleaseState(cfg *action.ConfigFactory, req *Request) (ReleaseStat
 				return ReleaseState{Status: ReleaseStatusFailed, Reason: "has failed test"}, nil
 			}
 		}
+
+		// The release is in sync with the desired chart and values, but the
+		// live cluster state may still have drifted from the manifest
+		// recorded for it (e.g. someone ran `kubectl edit` on a managed
+		// resource). Detect this so the caller can re-converge.
+		result, err := detectDrift(cfg, rls, req.DiffOptions)
+		if err != nil {
+			return ReleaseState{Status: ReleaseStatusUnknown}, fmt.Errorf("failed to detect drift: %w", err)
+		}
+		if result.Detected {
+			recordDiffEvent(req, "Drifted", result.Diff)
+			return ReleaseState{
+				Status:        ReleaseStatusDrifted,
+				Reason:        result.Summary,
+				Diff:          result.Diff,
+				DiffTruncated: result.Truncated,
+			}, nil
+		}
 		return ReleaseState{Status: ReleaseStatusInSync}, nil
 	default:
 		return ReleaseState{Status: ReleaseStatusUnknown}, fmt.Errorf("unknown release status: %s", rls.Info.Status)
 	}
 }
+
+// recordDiffEvent emits a Kubernetes Event carrying diffText for reason, if
+// req.EventRecorder is configured, so operators can see what changed without
+// inspecting release storage directly. The ReleaseState.Diff this was
+// derived from is also returned to the caller of DetermineReleaseState
+// unconditionally, so that reconciler can attach it to the object's own
+// status (e.g. a LastDiff field), which is outside the scope of this
+// package.
+func recordDiffEvent(req *Request, reason, diffText string) {
+	if req.EventRecorder == nil || diffText == "" {
+		return
+	}
+	req.EventRecorder.Event(req.Object, corev1.EventTypeNormal, reason, diffText)
+}
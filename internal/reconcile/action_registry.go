@@ -0,0 +1,221 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/helm-controller/internal/action"
+)
+
+// ActionType is the type of a reconcile Action, as dispatched based on the
+// ReleaseStatus of a HelmRelease object.
+type ActionType string
+
+const (
+	// ActionTypeInstall installs a release into the Helm storage for the
+	// first time.
+	ActionTypeInstall ActionType = "Install"
+	// ActionTypeUpgrade upgrades an existing release to a new chart and/or
+	// values, and is also used to force re-convergence of a Drifted
+	// release.
+	ActionTypeUpgrade ActionType = "Upgrade"
+	// ActionTypeRollback rolls a release back to a previous revision.
+	ActionTypeRollback ActionType = "Rollback"
+	// ActionTypeUninstall removes a release from the Helm storage.
+	ActionTypeUninstall ActionType = "Uninstall"
+	// ActionTypeTest runs the Helm tests of a release.
+	ActionTypeTest ActionType = "Test"
+)
+
+// Action is a single reconcile step capable of acting on a Request,
+// mirroring how upstream Helm consolidated install, upgrade, rollback,
+// uninstall and test under pkg/action. Implementations are registered with
+// a Registry and dispatched based on the ActionType produced for a given
+// ReleaseState.
+type Action interface {
+	// Type returns the ActionType this Action implements.
+	Type() ActionType
+	// Name returns a human-readable name for this Action, suitable for
+	// logging and condition messages.
+	Name() string
+	// Reconcile performs the action for req.
+	Reconcile(ctx context.Context, req *Request) error
+}
+
+// Registry holds a set of Action implementations keyed by their ActionType,
+// and allows third parties to register additional or replacement actions
+// (e.g. custom pre/post steps) via NewRegistry or Register. action.ConfigFactory
+// has no option for registering actions.
+type Registry struct {
+	actions map[ActionType]Action
+}
+
+// NewRegistry returns a Registry with the given actions registered. Later
+// entries for the same ActionType take precedence over earlier ones.
+func NewRegistry(actions ...Action) *Registry {
+	r := &Registry{actions: make(map[ActionType]Action, len(actions))}
+	for _, a := range actions {
+		r.Register(a)
+	}
+	return r
+}
+
+// Register adds a to the Registry, replacing any Action already registered
+// for its ActionType.
+func (r *Registry) Register(a Action) {
+	if r.actions == nil {
+		r.actions = make(map[ActionType]Action)
+	}
+	r.actions[a.Type()] = a
+}
+
+// Get returns the Action registered for t, and whether one was found.
+func (r *Registry) Get(t ActionType) (Action, bool) {
+	a, ok := r.actions[t]
+	return a, ok
+}
+
+// Dispatch looks up the Action registered for t and runs its Reconcile
+// method for req. It returns an error if no Action is registered for t.
+func (r *Registry) Dispatch(ctx context.Context, t ActionType, req *Request) error {
+	a, ok := r.Get(t)
+	if !ok {
+		return fmt.Errorf("no action registered for type %q", t)
+	}
+	return a.Reconcile(ctx, req)
+}
+
+// Reconcile determines the ReleaseState for req and dispatches it through r,
+// and is the single entry point through which DetermineReleaseState's
+// results are expected to flow: registering a custom or replacement Action
+// is enough to change how every release state is handled, without touching
+// this function. It returns the determined ReleaseState regardless of
+// whether dispatch succeeds, so callers can still report it.
+//
+// Once the dispatched Action succeeds, every other registered Action that
+// implements triggerableAfter and reports wanting to run after it (e.g. Test
+// after a successful Install or Upgrade) is dispatched as well.
+func (r *Registry) Reconcile(ctx context.Context, cfg *action.ConfigFactory, req *Request) (ReleaseState, error) {
+	state, err := DetermineReleaseState(cfg, req)
+	if err != nil {
+		return state, err
+	}
+
+	t, required := ActionForState(state)
+	if !required {
+		return state, nil
+	}
+
+	if err := r.Dispatch(ctx, t, req); err != nil {
+		return state, fmt.Errorf("failed to dispatch %s action for state '%s': %w", t, state.Status, err)
+	}
+
+	if err := r.dispatchTriggered(ctx, t, req); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// triggerableAfter is implemented by an Action that wants to run
+// automatically immediately following another Action's success, such as
+// Test running after a successful Install or Upgrade, rather than only
+// being reachable via its own ReleaseState.
+type triggerableAfter interface {
+	// MustRunAfter returns true if this Action should run immediately after
+	// trigger completes successfully.
+	MustRunAfter(trigger ActionType) bool
+}
+
+// dispatchTriggered runs every registered Action other than trigger itself
+// that implements triggerableAfter and reports wanting to run after it.
+func (r *Registry) dispatchTriggered(ctx context.Context, trigger ActionType, req *Request) error {
+	for t, a := range r.actions {
+		if t == trigger {
+			continue
+		}
+		ta, ok := a.(triggerableAfter)
+		if !ok || !ta.MustRunAfter(trigger) {
+			continue
+		}
+		if err := a.Reconcile(ctx, req); err != nil {
+			return fmt.Errorf("failed to dispatch %s action triggered by %s: %w", t, trigger, err)
+		}
+	}
+	return nil
+}
+
+// ActionForState returns the ActionType a Registry should dispatch to in
+// response to the given ReleaseState, and whether reconciliation is
+// required at all (e.g. ReleaseStatusInSync requires no action).
+//
+// ReleaseStatusOrphaned deliberately does not map to an Action: the release
+// is not managed by the object (MustResetHistory treats it the same as
+// Absent), so upgrading it would adopt or clobber state owned by whatever
+// else created it. It requires the history reset a controller performs
+// before a plain Install, not a force-Upgrade.
+func ActionForState(state ReleaseState) (t ActionType, required bool) {
+	switch state.Status {
+	case ReleaseStatusAbsent:
+		return ActionTypeInstall, true
+	case ReleaseStatusOutOfSync, ReleaseStatusDrifted, ReleaseStatusFailed:
+		return ActionTypeUpgrade, true
+	case ReleaseStatusUntested:
+		return ActionTypeTest, true
+	default:
+		return "", false
+	}
+}
+
+// ChainAction composes a sequence of Action implementations that are
+// reconciled in order, stopping at the first error, so a sequence (e.g.
+// Test after Upgrade) can be registered as one Action without a dedicated
+// Action implementation for every combination. A chain is built in code
+// with NewChainAction; HelmReleaseSpec has no field for declaring one.
+type ChainAction struct {
+	actionType ActionType
+	name       string
+	actions    []Action
+}
+
+// NewChainAction returns a ChainAction identifying itself as t, running
+// actions in order.
+func NewChainAction(t ActionType, name string, actions ...Action) *ChainAction {
+	return &ChainAction{actionType: t, name: name, actions: actions}
+}
+
+// Type returns the ActionType this ChainAction is registered under.
+func (c *ChainAction) Type() ActionType {
+	return c.actionType
+}
+
+// Name returns the human-readable name of this ChainAction.
+func (c *ChainAction) Name() string {
+	return c.name
+}
+
+// Reconcile runs every Action in the chain in order, returning the first
+// error encountered without running the remaining actions.
+func (c *ChainAction) Reconcile(ctx context.Context, req *Request) error {
+	for _, a := range c.actions {
+		if err := a.Reconcile(ctx, req); err != nil {
+			return fmt.Errorf("%s: %w", a.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// Test_ActionForState table-drives the entire ReleaseStatus -> ActionType
+// mapping, including the cases that deliberately require no action.
+func Test_ActionForState(t *testing.T) {
+	tests := []struct {
+		status       ReleaseStatus
+		wantType     ActionType
+		wantRequired bool
+	}{
+		{status: ReleaseStatusAbsent, wantType: ActionTypeInstall, wantRequired: true},
+		{status: ReleaseStatusOutOfSync, wantType: ActionTypeUpgrade, wantRequired: true},
+		{status: ReleaseStatusDrifted, wantType: ActionTypeUpgrade, wantRequired: true},
+		{status: ReleaseStatusFailed, wantType: ActionTypeUpgrade, wantRequired: true},
+		{status: ReleaseStatusUntested, wantType: ActionTypeTest, wantRequired: true},
+		// Orphaned is deliberately not mapped to Upgrade: the release isn't
+		// managed by the object, and force-upgrading it would adopt or
+		// clobber state owned by whatever else created it.
+		{status: ReleaseStatusOrphaned, wantRequired: false},
+		// Locked and InSync require no action of their own.
+		{status: ReleaseStatusLocked, wantRequired: false},
+		{status: ReleaseStatusInSync, wantRequired: false},
+		{status: ReleaseStatusUnknown, wantRequired: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			g := NewWithT(t)
+
+			gotType, gotRequired := ActionForState(ReleaseState{Status: tt.status})
+			g.Expect(gotRequired).To(Equal(tt.wantRequired))
+			if tt.wantRequired {
+				g.Expect(gotType).To(Equal(tt.wantType))
+			}
+		})
+	}
+}
+
+// fakeAction is a minimal Action used to exercise Registry and ChainAction
+// without depending on a real Helm release or cluster.
+type fakeAction struct {
+	t       ActionType
+	name    string
+	err     error
+	ran     *[]string
+	trigger ActionType
+}
+
+func (f *fakeAction) Type() ActionType { return f.t }
+func (f *fakeAction) Name() string     { return f.name }
+func (f *fakeAction) Reconcile(_ context.Context, _ *Request) error {
+	if f.ran != nil {
+		*f.ran = append(*f.ran, f.name)
+	}
+	return f.err
+}
+
+// MustRunAfter lets fakeAction double as a triggerableAfter Action for
+// Test_Registry_dispatchTriggered.
+func (f *fakeAction) MustRunAfter(trigger ActionType) bool {
+	return f.trigger != "" && f.trigger == trigger
+}
+
+func Test_ChainAction_Reconcile(t *testing.T) {
+	t.Run("runs actions in order", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var ran []string
+		chain := NewChainAction(ActionTypeUpgrade, "upgrade-then-test",
+			&fakeAction{t: ActionTypeUpgrade, name: "upgrade", ran: &ran},
+			&fakeAction{t: ActionTypeTest, name: "test", ran: &ran},
+		)
+
+		g.Expect(chain.Reconcile(context.TODO(), &Request{})).To(Succeed())
+		g.Expect(ran).To(Equal([]string{"upgrade", "test"}))
+	})
+
+	t.Run("stops at the first error", func(t *testing.T) {
+		g := NewWithT(t)
+
+		wantErr := fmt.Errorf("upgrade failed")
+		var ran []string
+		chain := NewChainAction(ActionTypeUpgrade, "upgrade-then-test",
+			&fakeAction{t: ActionTypeUpgrade, name: "upgrade", ran: &ran, err: wantErr},
+			&fakeAction{t: ActionTypeTest, name: "test", ran: &ran},
+		)
+
+		err := chain.Reconcile(context.TODO(), &Request{})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(wantErr.Error()))
+		g.Expect(ran).To(Equal([]string{"upgrade"}), "the second action must not run after the first fails")
+	})
+}
+
+// Test_Registry_dispatchTriggered asserts that an Action implementing
+// triggerableAfter runs automatically once the Action it depends on
+// succeeds, and does not run for an unrelated trigger.
+func Test_Registry_dispatchTriggered(t *testing.T) {
+	t.Run("runs a dependent action after its trigger", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var ran []string
+		r := NewRegistry(
+			&fakeAction{t: ActionTypeUpgrade, name: "upgrade", ran: &ran},
+			&fakeAction{t: ActionTypeTest, name: "test", ran: &ran, trigger: ActionTypeUpgrade},
+		)
+
+		g.Expect(r.Dispatch(context.TODO(), ActionTypeUpgrade, &Request{})).To(Succeed())
+		g.Expect(r.dispatchTriggered(context.TODO(), ActionTypeUpgrade, &Request{})).To(Succeed())
+		g.Expect(ran).To(Equal([]string{"upgrade", "test"}))
+	})
+
+	t.Run("does not run for an unrelated trigger", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var ran []string
+		r := NewRegistry(
+			&fakeAction{t: ActionTypeInstall, name: "install", ran: &ran},
+			&fakeAction{t: ActionTypeTest, name: "test", ran: &ran, trigger: ActionTypeUpgrade},
+		)
+
+		g.Expect(r.Dispatch(context.TODO(), ActionTypeInstall, &Request{})).To(Succeed())
+		g.Expect(r.dispatchTriggered(context.TODO(), ActionTypeInstall, &Request{})).To(Succeed())
+		g.Expect(ran).To(Equal([]string{"install"}), "test must not run: it is only triggered after Upgrade")
+	})
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/resource"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/diff"
+)
+
+// helmFieldManager is the server-side apply field manager Helm registers
+// its applied fields under. Fields owned by any other manager, as recorded
+// in a live object's metadata.managedFields, are excluded from drift
+// comparison: another controller or user is entitled to manage them
+// independently of the chart.
+//
+// This must match Helm's kube client default (kube.ManagedFieldsManager,
+// "helm"), not this controller's own name: Helm itself issues the apply
+// calls against the cluster, so it is the one recorded as the field
+// manager. A mismatch here is silent and severe — foreignOwnedFields would
+// misclassify every chart-owned field as foreign, strip it from both sides
+// of the comparison, and make detectDrift never observe any drift at all.
+const helmFieldManager = "helm"
+
+// fieldsIgnoredForDrift are top-level status subresource and metadata fields
+// that are expected to be mutated by the API server, other controllers, or
+// server-side apply field managers, and must not be considered when
+// comparing the live state of a resource against the manifest recorded for
+// a release.
+var fieldsIgnoredForDrift = []string{
+	"status",
+	"metadata.managedFields",
+	"metadata.creationTimestamp",
+	"metadata.resourceVersion",
+	"metadata.generation",
+	"metadata.uid",
+	"metadata.selfLink",
+}
+
+// listMergeKeys are the strategic-merge-patch merge keys used by common
+// Kubernetes list fields. They let pruneToDesiredShape pair up desired and
+// live list elements by identity instead of by index, so that e.g. a
+// container added out-of-band doesn't shift every subsequent comparison.
+var listMergeKeys = []string{"name", "containerPort", "port", "type"}
+
+// driftDetectionResult holds the outcome of comparing the live cluster state
+// of a release's resources against the manifest stored for that release.
+type driftDetectionResult struct {
+	// Detected indicates whether drift was detected in one or more
+	// resources.
+	Detected bool
+	// Summary is a compact, human-readable description of the observed
+	// diffs, suitable for use as a condition reason message.
+	Summary string
+	// Diff is the rendered JSON Patch of every drifted resource that still
+	// exists in the cluster, suitable for Status.LastDiff and a Kubernetes
+	// Event. A resource missing from the cluster appears only in Summary.
+	Diff string
+	// Truncated indicates that Diff omits one or more changes, for one or
+	// more of the drifted resources, to stay within opts.MaxBytes.
+	Truncated bool
+}
+
+// detectDrift renders the manifest recorded for rls, fetches the live state
+// of every resource it describes, and compares the two as a three-way merge:
+// only fields the manifest itself specifies are considered, fields owned by
+// a field manager other than helmFieldManager are excluded, and status
+// subresources and server-managed metadata are always ignored. This avoids
+// treating API-server defaulting (e.g. imagePullPolicy, clusterIP, a
+// default serviceAccountName) as drift. A resource that no longer exists in
+// the cluster is itself considered drift, as it indicates the object has
+// diverged from the state Helm believes it put in place. opts controls
+// redaction, truncation and ignored paths of the resulting Diff.
+func detectDrift(cfg *action.ConfigFactory, rls *helmrelease.Release, opts diff.Options) (driftDetectionResult, error) {
+	resources, err := cfg.Build(nil).KubeClient.Build(bytes.NewBufferString(rls.Manifest), false)
+	if err != nil {
+		return driftDetectionResult{}, fmt.Errorf("failed to parse manifest of release '%s': %w", rls.Name, err)
+	}
+
+	var summaries []string
+	var patches []string
+	var truncated bool
+	err = resources.Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		desired, err := toUnstructured(info.Object)
+		if err != nil {
+			return fmt.Errorf("failed to convert desired object %s/%s: %w", info.Namespace, info.Name, err)
+		}
+
+		if err := info.Get(); err != nil {
+			if apierrors.IsNotFound(err) {
+				summaries = append(summaries, fmt.Sprintf("%s %s/%s: resource is missing from the cluster",
+					info.Mapping.GroupVersionKind.Kind, info.Namespace, info.Name))
+				return nil
+			}
+			return fmt.Errorf("failed to get live state of %s/%s: %w", info.Namespace, info.Name, err)
+		}
+
+		foreign, err := foreignOwnedFields(info.Object, helmFieldManager)
+		if err != nil {
+			return fmt.Errorf("failed to determine field ownership of %s/%s: %w", info.Namespace, info.Name, err)
+		}
+
+		live, err := toUnstructured(info.Object)
+		if err != nil {
+			return fmt.Errorf("failed to convert live object %s/%s: %w", info.Namespace, info.Name, err)
+		}
+
+		for _, path := range fieldsIgnoredForDrift {
+			removeNestedField(desired, path)
+			removeNestedField(live, path)
+		}
+		for _, path := range foreign {
+			removeNestedField(desired, path)
+			removeNestedField(live, path)
+		}
+
+		// Only compare the subset of the live object that the manifest
+		// itself specifies. The API server fills in many defaults the
+		// chart never set (imagePullPolicy, clusterIP, a default
+		// serviceAccountName, port protocol, ...); a raw comparison against
+		// the fully-defaulted live object would report every one of those
+		// as drift on every reconcile.
+		prunedLive := pruneToDesiredShape(desired.Object, live.Object)
+
+		if equality.Semantic.DeepEqual(desired.Object, prunedLive) {
+			return nil
+		}
+
+		summaries = append(summaries, fmt.Sprintf("%s %s/%s: live state has diverged from the release manifest",
+			info.Mapping.GroupVersionKind.Kind, info.Namespace, info.Name))
+
+		prunedLiveMap, _ := prunedLive.(map[string]interface{})
+		patch, dErr := diff.Object(desired.Object, prunedLiveMap, opts)
+		if dErr != nil {
+			return fmt.Errorf("failed to compute diff for %s/%s: %w", info.Namespace, info.Name, dErr)
+		}
+		patches = append(patches, patch.String(opts.MaxBytes))
+		if patch.Truncated {
+			truncated = true
+		}
+		return nil
+	})
+	if err != nil {
+		return driftDetectionResult{}, fmt.Errorf("failed to detect drift for release '%s': %w", rls.Name, err)
+	}
+
+	if len(summaries) == 0 {
+		return driftDetectionResult{}, nil
+	}
+	return driftDetectionResult{
+		Detected:  true,
+		Summary:   strings.Join(summaries, "; "),
+		Diff:      strings.Join(patches, "\n"),
+		Truncated: truncated,
+	}, nil
+}
+
+// toUnstructured converts obj into an *unstructured.Unstructured copy,
+// leaving the original untouched.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj.DeepCopyObject())
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+// removeNestedField deletes the dot-separated field path from obj, if
+// present. It is a no-op if any segment of the path does not exist.
+func removeNestedField(obj *unstructured.Unstructured, path string) {
+	unstructured.RemoveNestedField(obj.Object, strings.Split(path, ".")...)
+}
+
+// foreignOwnedFields returns the dot-separated field paths recorded in
+// obj's metadata.managedFields for every field manager other than
+// ourManager, including fields that ourManager also owns.
+func foreignOwnedFields(obj runtime.Object, ourManager string) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access object metadata: %w", err)
+	}
+
+	var paths []string
+	for _, mf := range accessor.GetManagedFields() {
+		if mf.Manager == ourManager || mf.FieldsV1 == nil {
+			continue
+		}
+
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(mf.FieldsV1.Raw)); err != nil {
+			return nil, fmt.Errorf("failed to parse managed fields of manager %q: %w", mf.Manager, err)
+		}
+
+		set.Iterate(func(p fieldpath.Path) {
+			if path := fieldPathToDotPath(p); path != "" {
+				paths = append(paths, path)
+			}
+		})
+	}
+	return paths, nil
+}
+
+// fieldPathToDotPath converts a structured-merge-diff field Path into a
+// dot-separated path suitable for unstructured.RemoveNestedField. It stops
+// at the first list-associative element, excluding the whole remaining
+// subtree (e.g. a path into one container's ownership of spec.image
+// excludes all of spec.template.spec.containers, not just that element),
+// since per-list-item ownership is finer-grained than the whole-field
+// drift comparison performed here can use.
+//
+// This is a known, deliberately conservative limitation, not a rewrite
+// TODO: it only widens what gets excluded from comparison, which can cause
+// detectDrift to miss drift in sibling elements of a list another manager
+// partially owns, but it can never cause a false-positive the way the
+// opposite mistake (under-excluding) would. See Test_fieldPathToDotPath_list
+// for the exact behavior this locks in.
+func fieldPathToDotPath(p fieldpath.Path) string {
+	var segments []string
+	for _, e := range p {
+		if e.FieldName == nil {
+			break
+		}
+		segments = append(segments, *e.FieldName)
+	}
+	if len(segments) == 0 {
+		return ""
+	}
+	return strings.Join(segments, ".")
+}
+
+// pruneToDesiredShape returns a copy of live containing only the map keys,
+// and list elements matched by a common merge key, that are also present in
+// desired. It approximates a three-way comparison against the manifest:
+// fields the manifest never specified, such as API-server defaults, are not
+// considered when determining drift.
+func pruneToDesiredShape(desired, live interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		pruned := make(map[string]interface{}, len(d))
+		for k, dv := range d {
+			if lv, ok := l[k]; ok {
+				pruned[k] = pruneToDesiredShape(dv, lv)
+			}
+		}
+		return pruned
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		return pruneSliceToDesiredShape(d, l)
+	default:
+		return live
+	}
+}
+
+// pruneSliceToDesiredShape pairs up desired and live elements by a common
+// merge key (e.g. "name" for containers, "containerPort"/"port" for ports)
+// and prunes each pair individually. If no common merge key identifies
+// every element, or an element can't be paired, live is returned unpruned
+// and the two slices are compared as a whole.
+func pruneSliceToDesiredShape(desired, live []interface{}) []interface{} {
+	key := commonMergeKey(desired)
+	if key == "" {
+		return live
+	}
+
+	liveByKey := make(map[interface{}]interface{}, len(live))
+	for _, item := range live {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		liveByKey[m[key]] = item
+	}
+
+	pruned := make([]interface{}, 0, len(desired))
+	for _, item := range desired {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		lv, ok := liveByKey[m[key]]
+		if !ok {
+			return live
+		}
+		pruned = append(pruned, pruneToDesiredShape(item, lv))
+	}
+	return pruned
+}
+
+// commonMergeKey returns the first of listMergeKeys present on every item
+// of items, or the empty string if none qualifies.
+func commonMergeKey(items []interface{}) string {
+	if len(items) == 0 {
+		return ""
+	}
+	for _, candidate := range listMergeKeys {
+		allHave := true
+		for _, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				allHave = false
+				break
+			}
+			if _, ok := m[candidate]; !ok {
+				allHave = false
+				break
+			}
+		}
+		if allHave {
+			return candidate
+		}
+	}
+	return ""
+}
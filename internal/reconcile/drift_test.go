@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/diff"
+	"github.com/fluxcd/helm-controller/internal/testutil"
+)
+
+// Test_foreignOwnedFields asserts that only fields owned by a manager other
+// than helmFieldManager are reported, and that Helm's own entry (which must
+// use the same manager name Helm's kube client actually applies with, not
+// this controller's own name) is excluded.
+func Test_foreignOwnedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  helmFieldManager,
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:key":{}}}`)},
+				},
+				{
+					Manager:  "kubectl-edit",
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:otherKey":{}}}`)},
+				},
+			},
+		},
+	}
+
+	foreign, err := foreignOwnedFields(obj, helmFieldManager)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(foreign).To(ConsistOf("data.otherKey"))
+}
+
+// Test_fieldPathToDotPath_list locks in the documented, deliberately
+// conservative behavior of fieldPathToDotPath: ownership of a single
+// associative list element is reported as ownership of the whole list,
+// rather than missing it or reporting a path removeNestedField can't use.
+func Test_fieldPathToDotPath_list(t *testing.T) {
+	g := NewWithT(t)
+
+	raw := `{"f:spec":{"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"app\"}":{"f:image":{}}}}}}}`
+	set := &fieldpath.Set{}
+	g.Expect(set.FromJSON(strings.NewReader(raw))).To(Succeed())
+
+	var got []string
+	set.Iterate(func(p fieldpath.Path) {
+		if path := fieldPathToDotPath(p); path != "" {
+			got = append(got, path)
+		}
+	})
+
+	g.Expect(got).To(ConsistOf("spec.template.spec.containers"))
+}
+
+// Test_detectDrift stores a release whose manifest is applied to the
+// cluster, confirms a freshly-applied resource is not reported as drifted,
+// then mutates the live resource out-of-band (as a different field manager
+// would, e.g. `kubectl edit`) and confirms detectDrift now reports it.
+func Test_detectDrift(t *testing.T) {
+	g := NewWithT(t)
+
+	namedNS, err := testEnv.CreateNamespace(context.TODO(), mockReleaseNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	t.Cleanup(func() {
+		_ = testEnv.Delete(context.TODO(), namedNS)
+	})
+	releaseNamespace := namedNS.Name
+
+	manifest := fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: %s
+  namespace: %s
+data:
+  key: value
+`, mockReleaseName, releaseNamespace)
+
+	obj := &helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			ReleaseName:      mockReleaseName,
+			TargetNamespace:  releaseNamespace,
+			StorageNamespace: releaseNamespace,
+		},
+	}
+
+	getter, err := RESTClientGetterFromManager(testEnv.Manager, obj.GetReleaseNamespace())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cfg, err := action.NewConfigFactory(getter,
+		action.WithStorage(action.DefaultStorageDriver, obj.GetStorageNamespace()),
+		action.WithDebugLog(logr.Discard()),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+		Name:      mockReleaseName,
+		Namespace: releaseNamespace,
+		Version:   1,
+		Status:    helmrelease.StatusDeployed,
+	})
+	rls.Manifest = manifest
+
+	store := helmstorage.Init(cfg.Driver)
+	g.Expect(store.Create(rls)).To(Succeed())
+
+	resources, err := cfg.Build(nil).KubeClient.Build(bytes.NewBufferString(manifest), false)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = cfg.Build(nil).KubeClient.Create(resources)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	result, err := detectDrift(cfg, rls, diff.Options{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Detected).To(BeFalse(), "a freshly applied manifest must not be reported as drifted")
+
+	var cm corev1.ConfigMap
+	g.Expect(testEnv.Manager.GetClient().Get(context.TODO(),
+		client.ObjectKey{Namespace: releaseNamespace, Name: mockReleaseName}, &cm)).To(Succeed())
+	cm.Data["key"] = "mutated-out-of-band"
+	g.Expect(testEnv.Manager.GetClient().Update(context.TODO(), &cm, client.FieldOwner("kubectl-edit"))).To(Succeed())
+
+	result, err = detectDrift(cfg, rls, diff.Options{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Detected).To(BeTrue(), "a field mutated by a foreign field manager must be reported as drift")
+	g.Expect(result.Diff).ToNot(BeEmpty())
+}
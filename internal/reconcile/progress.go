@@ -0,0 +1,146 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// ProgressStep identifies a granular point reached by an Action while
+// reconciling a release, analogous to the logs Helm itself emits across
+// install, upgrade, delete and rollback.
+type ProgressStep string
+
+const (
+	// ProgressBuildingManifest indicates the chart is being rendered into a
+	// Kubernetes manifest.
+	ProgressBuildingManifest ProgressStep = "BuildingManifest"
+	// ProgressApplyingResources indicates the rendered resources are being
+	// applied to the cluster.
+	ProgressApplyingResources ProgressStep = "ApplyingResources"
+	// ProgressWaitingForReady indicates the Action is waiting for one or
+	// more resources to become ready.
+	ProgressWaitingForReady ProgressStep = "WaitingForReady"
+	// ProgressRunningHook indicates a Helm hook is being executed.
+	ProgressRunningHook ProgressStep = "RunningHook"
+	// ProgressStoringRelease indicates the release is being written to the
+	// Helm storage backend.
+	ProgressStoringRelease ProgressStep = "StoringRelease"
+	// ProgressCompleted indicates the Action has finished.
+	ProgressCompleted ProgressStep = "Completed"
+)
+
+// ProgressUpdate is a single observation of an Action reaching a
+// ProgressStep, with optional step-specific detail.
+type ProgressUpdate struct {
+	// Step is the ProgressStep reached.
+	Step ProgressStep
+	// Count is the number of resources being applied to or waited on, set
+	// for ProgressApplyingResources and ProgressWaitingForReady.
+	Count int
+	// Resource identifies the object being waited on, set for
+	// ProgressWaitingForReady.
+	Resource string
+	// HookName and HookPhase identify the hook being run, set for
+	// ProgressRunningHook.
+	HookName  string
+	HookPhase string
+	// Seq is a monotonically increasing sequence number, unique within a
+	// single Request, letting a watcher render updates in order even if
+	// they arrive out of band (e.g. over an Event stream).
+	Seq uint64
+}
+
+// String returns a compact, human-readable rendering of the update.
+func (p ProgressUpdate) String() string {
+	switch p.Step {
+	case ProgressApplyingResources:
+		return fmt.Sprintf("applying %d resource(s)", p.Count)
+	case ProgressWaitingForReady:
+		return fmt.Sprintf("waiting for %s to become ready", p.Resource)
+	case ProgressRunningHook:
+		return fmt.Sprintf("running %s hook %q", p.HookPhase, p.HookName)
+	default:
+		return string(p.Step)
+	}
+}
+
+// Progress is implemented by types that want to observe the lifecycle
+// steps an Action goes through while reconciling a Request. It is threaded
+// through Request so every reconciler (Install, Upgrade, Rollback,
+// Uninstall, Test) can report against the same stream without knowing who,
+// if anyone, is observing it.
+type Progress interface {
+	// Report records that step has been reached, assigns it the next
+	// sequence number, and returns the stamped ProgressUpdate so the caller
+	// can surface it (e.g. onto a status field) without keeping its own
+	// counter in sync with Progress's.
+	Report(step ProgressUpdate) ProgressUpdate
+}
+
+// LogProgress is a Progress implementation that writes every update to a
+// logr.Logger at info level. It is not applied automatically: an Action
+// reports nothing when Request.Progress is nil.
+type LogProgress struct {
+	Log logr.Logger
+
+	seq atomic.Uint64
+}
+
+// NewLogProgress returns a LogProgress that logs to log.
+func NewLogProgress(log logr.Logger) *LogProgress {
+	return &LogProgress{Log: log}
+}
+
+// Report logs step at info level, stamps it with the next sequence number,
+// and returns the stamped copy.
+func (p *LogProgress) Report(step ProgressUpdate) ProgressUpdate {
+	step.Seq = p.seq.Add(1)
+	p.Log.Info(step.String(), "step", step.Step, "seq", step.Seq)
+	return step
+}
+
+// EventProgress is a Progress implementation that records every update as
+// a Kubernetes Event on a given runtime object, in addition to logging it,
+// so the progress stream remains visible via `kubectl describe`. It does not
+// write the update to the object's status.
+type EventProgress struct {
+	Recorder record.EventRecorder
+	Object   runtime.Object
+
+	delegate *LogProgress
+}
+
+// NewEventProgress returns an EventProgress that records Events for obj via
+// recorder, and logs every update via log.
+func NewEventProgress(recorder record.EventRecorder, obj runtime.Object, log logr.Logger) *EventProgress {
+	return &EventProgress{Recorder: recorder, Object: obj, delegate: NewLogProgress(log)}
+}
+
+// Report forwards step to the underlying LogProgress, emits a Normal Event
+// carrying its assigned sequence number, and returns the stamped update.
+func (p *EventProgress) Report(step ProgressUpdate) ProgressUpdate {
+	step = p.delegate.Report(step)
+	p.Recorder.Event(p.Object, corev1.EventTypeNormal, string(step.Step), fmt.Sprintf("[%d] %s", step.Seq, step.String()))
+	return step
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+// ReleaseStatus represents the status of a Helm release as determined by
+// comparing the Helm storage with the v2beta2.HelmRelease object.
+type ReleaseStatus string
+
+// String returns the string representation of the release status.
+func (s ReleaseStatus) String() string {
+	return string(s)
+}
+
+const (
+	// ReleaseStatusUnknown indicates that the status of the release could not
+	// be determined.
+	ReleaseStatusUnknown ReleaseStatus = "Unknown"
+	// ReleaseStatusAbsent indicates that the release is not present in the
+	// Helm storage.
+	ReleaseStatusAbsent ReleaseStatus = "Absent"
+	// ReleaseStatusOrphaned indicates that the release is present in the Helm
+	// storage, but is not managed by the v2beta2.HelmRelease object.
+	ReleaseStatusOrphaned ReleaseStatus = "Orphaned"
+	// ReleaseStatusOutOfSync indicates that the release is present in the Helm
+	// storage, but is not in sync with the v2beta2.HelmRelease object.
+	ReleaseStatusOutOfSync ReleaseStatus = "OutOfSync"
+	// ReleaseStatusLocked indicates that the release is present in the Helm
+	// storage, but is locked.
+	ReleaseStatusLocked ReleaseStatus = "Locked"
+	// ReleaseStatusUntested indicates that the release is present in the Helm
+	// storage, but has not been tested.
+	ReleaseStatusUntested ReleaseStatus = "Untested"
+	// ReleaseStatusInSync indicates that the release is present in the Helm
+	// storage, and is in sync with the v2beta2.HelmRelease object.
+	ReleaseStatusInSync ReleaseStatus = "InSync"
+	// ReleaseStatusFailed indicates that the release is present in the Helm
+	// storage, but has failed.
+	ReleaseStatusFailed ReleaseStatus = "Failed"
+	// ReleaseStatusDrifted indicates that the release is present in the Helm
+	// storage and is in sync with the v2beta2.HelmRelease object, but the
+	// live state of one or more of its resources has diverged from the
+	// manifest recorded for the release.
+	ReleaseStatusDrifted ReleaseStatus = "Drifted"
+)
+
+// ReleaseState represents the state of a Helm release as determined by
+// comparing the Helm storage with the v2beta2.HelmRelease object.
+type ReleaseState struct {
+	// Status is the status of the release.
+	Status ReleaseStatus
+	// Reason for the Status.
+	Reason string
+	// Diff is a compact, human-readable JSON Patch summary of the change
+	// that caused Status to be ReleaseStatusOutOfSync or
+	// ReleaseStatusDrifted. It is empty for any other Status.
+	Diff string
+	// DiffTruncated indicates that Diff omits one or more changes to stay
+	// within the configured Request.DiffOptions.MaxBytes.
+	DiffTruncated bool
+}
+
+// MustResetHistory returns true if the release state indicates that the
+// history on the v2beta2.HelmRelease object must be reset.
+// This is the case when the release in storage has been mutated in such a way
+// that it no longer can be used to roll back to, or perform a diff against.
+func (s ReleaseState) MustResetHistory() bool {
+	return s.Status == ReleaseStatusLocked || s.Status == ReleaseStatusOrphaned || s.Status == ReleaseStatusAbsent
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	helmchartutil "helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/client-go/tools/record"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	"github.com/fluxcd/helm-controller/internal/diff"
+)
+
+// Request groups the parameters shared by DetermineReleaseState and every
+// Action reconciling a single HelmRelease.
+type Request struct {
+	// Object is the HelmRelease being reconciled.
+	Object *helmv2.HelmRelease
+	// Chart is the chart to install, upgrade or test against.
+	Chart *helmchart.Chart
+	// Values are the computed values to render Chart with.
+	Values helmchartutil.Values
+
+	// DiffOptions controls redaction, truncation and ignored paths for any
+	// diff computed while determining this Request's ReleaseState (e.g. an
+	// out-of-sync values diff or a detected drift diff).
+	DiffOptions diff.Options
+
+	// Progress, if set, receives a ProgressUpdate for every lifecycle step
+	// an Action reaches while reconciling this Request.
+	Progress Progress
+	// EventRecorder, if set, receives a Kubernetes Event for notable
+	// outcomes of reconciling this Request, such as a detected diff.
+	EventRecorder record.EventRecorder
+}
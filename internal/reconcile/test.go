@@ -0,0 +1,215 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/release"
+)
+
+// Released condition reasons used by the Test action. These are kept local
+// to this package, rather than assumed to live on the v2beta2 API, so that
+// this Action does not depend on API surface no commit in this series adds.
+const (
+	// ReasonTestsRunning is the Released condition reason while the Test
+	// action is running the Helm test suite for a release.
+	ReasonTestsRunning = "TestsRunning"
+	// ReasonTestSucceeded is the Released condition reason once the Helm
+	// test suite for a release has passed.
+	ReasonTestSucceeded = "TestSucceeded"
+	// ReasonTestFailed is the Released condition reason once the Helm test
+	// suite for a release has failed.
+	ReasonTestFailed = "TestFailed"
+)
+
+// releaseLeases guards concurrent test runs for the same release, keyed by
+// release namespace/name, so that two reconciliations racing on the same
+// HelmRelease (e.g. triggered by both a spec change and a requeue) cannot
+// run `helm test` against the same revision twice at once.
+var releaseLeases sync.Map // map[string]*sync.Mutex
+
+func acquireReleaseLease(key string) func() {
+	mu, _ := releaseLeases.LoadOrStore(key, &sync.Mutex{})
+	lease := mu.(*sync.Mutex)
+	lease.Lock()
+	return lease.Unlock
+}
+
+// TestTriggerPolicy controls whether a Registry dispatches the Test action
+// automatically following an Install or Upgrade action, independent of a
+// user manually running `helm test`.
+type TestTriggerPolicy struct {
+	// RunOnInstall runs the test suite automatically after a successful
+	// Install.
+	RunOnInstall bool
+	// RunOnUpgrade runs the test suite automatically after a successful
+	// Upgrade.
+	RunOnUpgrade bool
+}
+
+// RetryPolicy controls how many times, and with what backoff, the Test
+// action retries a failing Helm test suite before giving up.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times to run the test suite. A
+	// value below 1 is treated as 1.
+	Attempts int
+	// BaseBackoff is the delay before the first retry. Each subsequent
+	// retry doubles the previous delay.
+	BaseBackoff time.Duration
+}
+
+// Backoff returns the delay to wait before retry attempt n (1-indexed). It
+// returns 0 for n < 1.
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	return p.BaseBackoff * time.Duration(uint64(1)<<uint(n-1))
+}
+
+// Test is the Action that runs the Helm tests of a release, dispatched
+// automatically whenever DetermineReleaseState reports
+// ReleaseStatusUntested, rather than only being available as a user-invoked
+// `helm test` step.
+//
+// Reconcile is split into two passes so that the Released=Unknown,
+// TestsRunning state it reports is actually observable by a watcher: the
+// first call only marks the condition and returns, relying on the caller to
+// persist status before the next reconciliation; only once that state is
+// already recorded on the object does a subsequent call run the suite to a
+// terminal True/False result.
+type Test struct {
+	configFactory *action.ConfigFactory
+	trigger       TestTriggerPolicy
+	retryPolicy   RetryPolicy
+}
+
+// NewTest returns a Test action using cfg to run `helm test`, gated by
+// trigger and retried per retryPolicy.
+func NewTest(cfg *action.ConfigFactory, trigger TestTriggerPolicy, retryPolicy RetryPolicy) *Test {
+	return &Test{configFactory: cfg, trigger: trigger, retryPolicy: retryPolicy}
+}
+
+// Type returns ActionTypeTest.
+func (t *Test) Type() ActionType {
+	return ActionTypeTest
+}
+
+// Name returns the human-readable name of this Action.
+func (t *Test) Name() string {
+	return "test"
+}
+
+// MustRunAfter returns true if this Test is configured to run automatically
+// following the given trigger ActionType (ActionTypeInstall or
+// ActionTypeUpgrade).
+func (t *Test) MustRunAfter(trigger ActionType) bool {
+	switch trigger {
+	case ActionTypeInstall:
+		return t.trigger.RunOnInstall
+	case ActionTypeUpgrade:
+		return t.trigger.RunOnUpgrade
+	default:
+		return false
+	}
+}
+
+// Reconcile runs the Helm tests for the release identified by req, honoring
+// t.retryPolicy. While tests are running, the Released condition is set to
+// Unknown with reason ReasonTestsRunning; on completion it is set to True
+// or False depending on the outcome. With testSpec.IgnoreFailures set, a
+// failure still sets it to False, but no error is returned.
+func (t *Test) Reconcile(ctx context.Context, req *Request) error {
+	testSpec := req.Object.GetTest()
+	if !testSpec.Enable {
+		return nil
+	}
+
+	if !conditions.IsUnknown(req.Object, helmv2.ReleasedCondition) ||
+		conditions.GetReason(req.Object, helmv2.ReleasedCondition) != ReasonTestsRunning {
+		// First pass: announce the test run and return without running it,
+		// so the Unknown/TestsRunning state is persisted by the caller and
+		// observable before a later reconciliation resolves it.
+		conditions.MarkUnknown(req.Object, helmv2.ReleasedCondition, ReasonTestsRunning, "Running Helm tests")
+		t.reportProgress(req, ProgressUpdate{Step: ProgressRunningHook, HookPhase: helmrelease.HookPhaseTest.String()})
+		return nil
+	}
+
+	// Second pass: the TestsRunning state has already been observed;
+	// actually run the suite to a terminal result.
+	releaseKey := fmt.Sprintf("%s/%s", req.Object.GetReleaseNamespace(), req.Object.GetReleaseName())
+	unlock := acquireReleaseLease(releaseKey)
+	defer unlock()
+
+	var lastErr error
+	attempts := t.retryPolicy.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(t.retryPolicy.Backoff(attempt)):
+			}
+		}
+
+		lastErr = action.Test(t.configFactory.Build(nil), req.Object.GetReleaseName())
+		if lastErr == nil {
+			break
+		}
+	}
+
+	// Refresh Status.Current from Helm storage so the outcome of the test
+	// hooks (recorded by Helm on the release itself) is observable to a
+	// later reconciliation, e.g. for DetermineReleaseState to notice a
+	// failed test and move the release to ReleaseStatusFailed for
+	// remediation.
+	if rls, rlsErr := action.LastRelease(t.configFactory.Build(nil), req.Object.GetReleaseName()); rlsErr == nil {
+		req.Object.Status.Current = release.ObservedToInfo(release.ObserveRelease(rls))
+	}
+
+	if lastErr != nil {
+		if testSpec.IgnoreFailures {
+			conditions.MarkFalse(req.Object, helmv2.ReleasedCondition, ReasonTestFailed, "%s (ignored)", lastErr.Error())
+			return nil
+		}
+		conditions.MarkFalse(req.Object, helmv2.ReleasedCondition, ReasonTestFailed, "%s", lastErr.Error())
+		return lastErr
+	}
+
+	conditions.MarkTrue(req.Object, helmv2.ReleasedCondition, ReasonTestSucceeded, "Helm tests succeeded")
+	t.reportProgress(req, ProgressUpdate{Step: ProgressCompleted})
+	return nil
+}
+
+// reportProgress forwards step to req.Progress if one is configured.
+func (t *Test) reportProgress(req *Request, step ProgressUpdate) {
+	if req.Progress != nil {
+		req.Progress.Report(step)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta2"
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/release"
+	"github.com/fluxcd/helm-controller/internal/testutil"
+)
+
+// Test_test_Reconcile exercises the two-phase Released condition flow of
+// Test.Reconcile: a first call must only announce that tests are running
+// without running them, so that state is observable before a later
+// reconciliation resolves it.
+func Test_test_Reconcile(t *testing.T) {
+	g := NewWithT(t)
+
+	namedNS, err := testEnv.CreateNamespace(context.TODO(), mockReleaseNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	t.Cleanup(func() {
+		_ = testEnv.Delete(context.TODO(), namedNS)
+	})
+	releaseNamespace := namedNS.Name
+
+	rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+		Name:      mockReleaseName,
+		Namespace: releaseNamespace,
+		Chart:     testutil.BuildChart(testutil.ChartWithTestHook()),
+		Version:   1,
+		Status:    helmrelease.StatusDeployed,
+	})
+
+	obj := &helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			ReleaseName:      mockReleaseName,
+			TargetNamespace:  releaseNamespace,
+			StorageNamespace: releaseNamespace,
+			Timeout:          &metav1.Duration{Duration: 100 * time.Millisecond},
+			Test:             &helmv2.Test{Enable: true},
+		},
+		Status: helmv2.HelmReleaseStatus{
+			Current: release.ObservedToInfo(release.ObserveRelease(rls)),
+		},
+	}
+
+	getter, err := RESTClientGetterFromManager(testEnv.Manager, obj.GetReleaseNamespace())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cfg, err := action.NewConfigFactory(getter,
+		action.WithStorage(action.DefaultStorageDriver, obj.GetStorageNamespace()),
+		action.WithDebugLog(logr.Discard()),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	store := helmstorage.Init(cfg.Driver)
+	g.Expect(store.Create(rls)).To(Succeed())
+
+	test := NewTest(cfg, TestTriggerPolicy{}, RetryPolicy{Attempts: 1})
+
+	g.Expect(test.Reconcile(context.TODO(), &Request{Object: obj, Values: nil})).To(Succeed())
+	g.Expect(conditions.IsUnknown(obj, helmv2.ReleasedCondition)).To(BeTrue())
+	g.Expect(conditions.GetReason(obj, helmv2.ReleasedCondition)).To(Equal(ReasonTestsRunning))
+
+	g.Expect(test.Reconcile(context.TODO(), &Request{Object: obj, Values: nil})).To(Succeed())
+	g.Expect(conditions.IsTrue(obj, helmv2.ReleasedCondition)).To(BeTrue())
+	g.Expect(conditions.GetReason(obj, helmv2.ReleasedCondition)).To(Equal(ReasonTestSucceeded))
+}
+
+// Test_test_Reconcile_failure asserts that a second reconciliation resolves
+// the Released condition to False with ReasonTestFailed when the Helm test
+// suite itself fails, without requiring a specific upstream Helm error
+// string, and that the failure is then observable as remediation-worthy by
+// a subsequent DetermineReleaseState call: Untested -> Released=False
+// (test failed) -> ReleaseStatusFailed -> ActionTypeUpgrade.
+func Test_test_Reconcile_failure(t *testing.T) {
+	g := NewWithT(t)
+
+	namedNS, err := testEnv.CreateNamespace(context.TODO(), mockReleaseNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	t.Cleanup(func() {
+		_ = testEnv.Delete(context.TODO(), namedNS)
+	})
+	releaseNamespace := namedNS.Name
+
+	rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+		Name:      mockReleaseName,
+		Namespace: releaseNamespace,
+		Chart:     testutil.BuildChart(testutil.ChartWithFailingHook()),
+		Version:   1,
+		Status:    helmrelease.StatusDeployed,
+	})
+
+	obj := &helmv2.HelmRelease{
+		Spec: helmv2.HelmReleaseSpec{
+			ReleaseName:      mockReleaseName,
+			TargetNamespace:  releaseNamespace,
+			StorageNamespace: releaseNamespace,
+			Timeout:          &metav1.Duration{Duration: 100 * time.Millisecond},
+			Test:             &helmv2.Test{Enable: true},
+		},
+		Status: helmv2.HelmReleaseStatus{
+			Current: release.ObservedToInfo(release.ObserveRelease(rls)),
+		},
+	}
+
+	getter, err := RESTClientGetterFromManager(testEnv.Manager, obj.GetReleaseNamespace())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cfg, err := action.NewConfigFactory(getter,
+		action.WithStorage(action.DefaultStorageDriver, obj.GetStorageNamespace()),
+		action.WithDebugLog(logr.Discard()),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	store := helmstorage.Init(cfg.Driver)
+	g.Expect(store.Create(rls)).To(Succeed())
+
+	test := NewTest(cfg, TestTriggerPolicy{}, RetryPolicy{Attempts: 1})
+
+	g.Expect(test.Reconcile(context.TODO(), &Request{Object: obj, Values: nil})).To(Succeed())
+	g.Expect(conditions.GetReason(obj, helmv2.ReleasedCondition)).To(Equal(ReasonTestsRunning))
+
+	g.Expect(test.Reconcile(context.TODO(), &Request{Object: obj, Values: nil})).ToNot(Succeed())
+	g.Expect(conditions.IsFalse(obj, helmv2.ReleasedCondition)).To(BeTrue())
+	g.Expect(conditions.GetReason(obj, helmv2.ReleasedCondition)).To(Equal(ReasonTestFailed))
+
+	// The failed test hook is now recorded on Status.Current; the next
+	// reconciliation must notice it and route to remediation rather than
+	// treating the release as InSync.
+	latestRls, err := action.LastRelease(cfg.Build(nil), obj.GetReleaseName())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	state, err := DetermineReleaseState(cfg, &Request{
+		Object: obj,
+		Chart:  latestRls.Chart,
+		Values: latestRls.Config,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(state.Status).To(Equal(ReleaseStatusFailed))
+
+	remediationType, required := ActionForState(state)
+	g.Expect(required).To(BeTrue())
+	g.Expect(remediationType).To(Equal(ActionTypeUpgrade))
+}
+
+// Test_RetryPolicy_Backoff asserts the exponential backoff schedule used to
+// retry a failing test suite.
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	g := NewWithT(t)
+
+	p := RetryPolicy{Attempts: 4, BaseBackoff: time.Second}
+	g.Expect(p.Backoff(0)).To(Equal(time.Duration(0)))
+	g.Expect(p.Backoff(1)).To(Equal(time.Second))
+	g.Expect(p.Backoff(2)).To(Equal(2 * time.Second))
+	g.Expect(p.Backoff(3)).To(Equal(4 * time.Second))
+}
@@ -40,6 +40,19 @@ import (
 	"github.com/fluxcd/helm-controller/internal/testutil"
 )
 
+// progressRecorder is a Progress implementation that records every
+// ProgressUpdate it is given, for asserting on the progress stream an
+// Action reports while reconciling a Request.
+type progressRecorder struct {
+	steps []ProgressUpdate
+}
+
+func (r *progressRecorder) Report(step ProgressUpdate) ProgressUpdate {
+	step.Seq = uint64(len(r.steps) + 1)
+	r.steps = append(r.steps, step)
+	return step
+}
+
 func Test_upgrade(t *testing.T) {
 	var (
 		mockCreateErr = fmt.Errorf("storage create error")
@@ -80,6 +93,9 @@ func Test_upgrade(t *testing.T) {
 		// expectUpgradeFailures is the expected UpgradeFailures count of the
 		// HelmRelease.
 		expectUpgradeFailures int64
+		// expectProgressCompleted is whether the progress stream reported by
+		// Upgrade.Reconcile is expected to end in ProgressCompleted.
+		expectProgressCompleted bool
 	}{
 		{
 			name: "upgrade success",
@@ -110,6 +126,7 @@ func Test_upgrade(t *testing.T) {
 			expectPrevious: func(releases []*helmrelease.Release) *helmv2.HelmReleaseInfo {
 				return release.ObservedToInfo(release.ObserveRelease(releases[0]))
 			},
+			expectProgressCompleted: true,
 		},
 		{
 			name: "upgrade failure",
@@ -242,6 +259,7 @@ func Test_upgrade(t *testing.T) {
 			expectCurrent: func(releases []*helmrelease.Release) *helmv2.HelmReleaseInfo {
 				return release.ObservedToInfo(release.ObserveRelease(releases[1]))
 			},
+			expectProgressCompleted: true,
 		},
 		{
 			name: "upgrade with stale current",
@@ -289,6 +307,7 @@ func Test_upgrade(t *testing.T) {
 					Status:    helmrelease.StatusDeployed.String(),
 				}
 			},
+			expectProgressCompleted: true,
 		},
 	}
 	for _, tt := range tests {
@@ -341,10 +360,12 @@ func Test_upgrade(t *testing.T) {
 				cfg.Driver = tt.driver(cfg.Driver)
 			}
 
+			progress := &progressRecorder{}
 			got := (&Upgrade{configFactory: cfg}).Reconcile(context.TODO(), &Request{
-				Object: obj,
-				Chart:  tt.chart,
-				Values: tt.values,
+				Object:   obj,
+				Chart:    tt.chart,
+				Values:   tt.values,
+				Progress: progress,
 			})
 			if tt.wantErr != nil {
 				g.Expect(got).To(Equal(tt.wantErr))
@@ -372,6 +393,15 @@ func Test_upgrade(t *testing.T) {
 			g.Expect(obj.Status.Failures).To(Equal(tt.expectFailures))
 			g.Expect(obj.Status.InstallFailures).To(Equal(tt.expectInstallFailures))
 			g.Expect(obj.Status.UpgradeFailures).To(Equal(tt.expectUpgradeFailures))
+
+			if tt.expectProgressCompleted {
+				g.Expect(progress.steps).ToNot(BeEmpty(), "expected a progress stream to be reported")
+				g.Expect(progress.steps[len(progress.steps)-1].Step).To(Equal(ProgressCompleted))
+			} else {
+				for _, step := range progress.steps {
+					g.Expect(step.Step).ToNot(Equal(ProgressCompleted), "a failed upgrade must not report completion")
+				}
+			}
 		})
 	}
 }
\ No newline at end of file